@@ -3,14 +3,13 @@
 package azure
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"errors"
+	"crypto/md5"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,7 +17,10 @@ import (
 	"github.com/docker/distribution/registry/storage/driver/base"
 	"github.com/docker/distribution/registry/storage/driver/factory"
 
-	azure "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 )
 
 const driverName = "azure"
@@ -29,203 +31,335 @@ const (
 	paramConnectionString = "connectionstring"
 	paramContainer        = "container"
 	paramRealm            = "realm"
+	paramServiceURL       = "serviceurl"
 	paramRootDirectory    = "rootdirectory"
-	maxChunkSize          = 4 * 1024 * 1024
+	paramChunkSize        = "chunksize"
+	paramMaxConcurrency   = "maxconcurrency"
+
+	paramUseDefaultCredential = "usedefaultcredential"
+	paramTenantID             = "tenantid"
+	paramClientID             = "clientid"
+	paramClientSecret         = "clientsecret"
+	paramUseMSI               = "usemsi"
+	paramMSIClientID          = "msiclientid"
+
+	// defaultRealm is used to build the account's blob endpoint when
+	// neither serviceurl nor realm is configured.
+	defaultRealm = "core.windows.net"
+
+	// defaultChunkSize is the size of a block staged via StageBlock when the
+	// caller does not configure one explicitly.
+	defaultChunkSize = 4 * 1024 * 1024
+	// minChunkSize is the smallest block size Azure will accept.
+	minChunkSize = 1
+	// maxChunkSize is the largest block size a single StageBlock call may
+	// use, per the current Azure block blob limits (4000 MiB/block, 50,000
+	// blocks/blob => ~190 TiB/blob).
+	maxChunkSize = 4000 * 1024 * 1024
+
+	// defaultMaxConcurrency bounds how many blocks are staged in parallel
+	// when the caller does not configure maxconcurrency explicitly.
+	defaultMaxConcurrency = 8
+
+	// copyPollInterval is how often Move polls a pending StartCopyFromURL
+	// for completion.
+	copyPollInterval = 100 * time.Millisecond
+	// copyPollTimeout bounds the total time Move will wait for a copy to
+	// leave the Pending state before giving up.
+	copyPollTimeout = 15 * time.Minute
 )
 
-type blobClient interface {
-	GetBlobReference(path string) *azure.Blob
-	GetContainerReference() *azure.Container
-	GetCopySourceURL(blob *azure.Blob) (url string)
-	GetURLFor(blob *azure.Blob, expires time.Time) (url string, err error)
-	CreateContainer() (created bool, err error)
+type driver struct {
+	client         blobClient
+	rootDirectory  string
+	chunkSize      int
+	maxConcurrency uint64
+	tiers          tierPolicy
+	useCRC64       bool
+	retry          retryPolicy
+	ignoreNotFound bool
 }
 
-type simpleBlobClient struct {
-	client    *azure.BlobStorageClient
-	container string
-}
+type baseEmbed struct{ base.Base }
 
-func (client *simpleBlobClient) GetBlobReference(path string) *azure.Blob {
-	return client.GetContainerReference().GetBlobReference(path)
-}
+// Driver is a storagedriver.StorageDriver implementation backed by
+// Microsoft Azure Blob Storage Service.
+type Driver struct{ baseEmbed }
 
-func (client *simpleBlobClient) GetContainerReference() *azure.Container {
-	return client.client.GetContainerReference(client.container)
+func init() {
+	factory.Register(driverName, &azureDriverFactory{})
 }
 
-func (client *simpleBlobClient) CreateContainer() (created bool, err error) {
-	return client.GetContainerReference().CreateIfNotExists(nil)
-}
+type azureDriverFactory struct{}
 
-func (client *simpleBlobClient) GetURLFor(blob *azure.Blob, expires time.Time) (url string, err error) {
-	return blob.GetSASURI(azure.BlobSASOptions{
-		BlobServiceSASPermissions: azure.BlobServiceSASPermissions{
-			Read: true,
-		},
-		SASOptions: azure.SASOptions{
-			Expiry: expires,
-		},
-	})
+func (factory *azureDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
 }
 
-func (client *simpleBlobClient) GetCopySourceURL(blob *azure.Blob) (url string) {
-	return blob.GetURL()
-}
+// FromParameters constructs a new Driver with a given parameters map.
+//
+// Exactly one authentication mode is selected, in this order of
+// precedence: a connectionstring (account key or SAS), usedefaultcredential
+// (azidentity.DefaultAzureCredential: managed identity, workload identity,
+// environment, or the Azure CLI), tenantid/clientid/clientsecret (an Azure
+// AD service principal), usemsi (a managed identity, optionally
+// user-assigned via msiclientid), and finally accountname/accountkey.
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	rootDirectory := paramString(parameters, paramRootDirectory, "")
 
-type accountSASBlobClient struct {
-	client          *azure.BlobStorageClient
-	accountSASToken string
-	container       string
-}
+	containerName := paramString(parameters, paramContainer, "")
+	if containerName == "" {
+		return nil, fmt.Errorf("no %s parameter provided", paramContainer)
+	}
 
-func NewAccountSASClient(connectionString, container string) (client *accountSASBlobClient, err error) {
-	// build a map of connection string key/value pairs
-	parts := map[string]string{}
-	for _, pair := range strings.Split(connectionString, ";") {
-		if pair == "" {
-			continue
+	chunkSize := int64(defaultChunkSize)
+	if v, ok := parameters[paramChunkSize]; ok {
+		size, err := parseInt64Param(paramChunkSize, v)
+		if err != nil {
+			return nil, err
 		}
+		chunkSize = size
+	}
+	if chunkSize < minChunkSize || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("%s parameter must be between %d and %d bytes, got %d", paramChunkSize, minChunkSize, maxChunkSize, chunkSize)
+	}
 
-		equalDex := strings.IndexByte(pair, '=')
-		if equalDex <= 0 {
-			return nil, fmt.Errorf("Invalid connection segment %q", pair)
+	maxConcurrency := uint64(defaultMaxConcurrency)
+	if v, ok := parameters[paramMaxConcurrency]; ok {
+		concurrency, err := parseInt64Param(paramMaxConcurrency, v)
+		if err != nil {
+			return nil, err
 		}
-
-		value := strings.TrimSpace(pair[equalDex+1:])
-		key := strings.TrimSpace(strings.ToLower(pair[:equalDex]))
-		parts[key] = value
+		if concurrency < 1 {
+			return nil, fmt.Errorf("%s parameter must be positive, got %d", paramMaxConcurrency, concurrency)
+		}
+		maxConcurrency = uint64(concurrency)
 	}
-	accountSASToken := parts["sharedaccesssignature"]
-	if accountSASToken != "" {
-		azClient, err := azure.NewClientFromConnectionString(connectionString)
+
+	serviceURL := paramString(parameters, paramServiceURL, "")
+
+	var tiers tierPolicy
+	if v := paramString(parameters, paramDefaultTier, ""); v != "" {
+		t, err := parseAccessTier(paramDefaultTier, v)
 		if err != nil {
 			return nil, err
 		}
-		api := azClient.GetBlobService()
-		sasClient := &accountSASBlobClient{
-			client:          &api,
-			accountSASToken: accountSASToken,
-			container:       container,
+		tiers.defaultTier = t
+	}
+	overrides, err := parseTierOverrides(paramString(parameters, paramTierOverrides, ""))
+	if err != nil {
+		return nil, err
+	}
+	tiers.overrides = overrides
+	tiers.autoRehydrate = paramBool(parameters, paramAutoRehydrate, false)
+	tiers.rehydratePriority, err = parseRehydratePriority(paramString(parameters, paramRehydratePriority, ""))
+	if err != nil {
+		return nil, err
+	}
+	tiers.rehydrateToTier = blob.AccessTierHot
+	if v := paramString(parameters, paramRehydrateToTier, ""); v != "" {
+		tiers.rehydrateToTier, err = parseAccessTier(paramRehydrateToTier, v)
+		if err != nil {
+			return nil, err
 		}
-		return sasClient, nil
 	}
-	return nil, errors.New("Expected a SharedAccessSignature in the connection string")
-}
 
-func (client *accountSASBlobClient) GetBlobReference(path string) *azure.Blob {
-	return client.GetContainerReference().GetBlobReference(path)
-}
+	useCRC64 := paramBool(parameters, paramUseCRC64, false)
 
-func (client *accountSASBlobClient) CreateContainer() (created bool, err error) {
-	return false, nil
-}
+	retryDelay := defaultRetryDelay
+	if v, ok := parameters[paramRetryDelay]; ok {
+		d, err := parseDurationParam(paramRetryDelay, v)
+		if err != nil {
+			return nil, err
+		}
+		retryDelay = d
+	}
+	maxRetryDelay := defaultMaxRetryDelay
+	if v, ok := parameters[paramMaxRetryDelay]; ok {
+		d, err := parseDurationParam(paramMaxRetryDelay, v)
+		if err != nil {
+			return nil, err
+		}
+		maxRetryDelay = d
+	}
+	maxRetries := int64(defaultMaxRetries)
+	if v, ok := parameters[paramMaxRetries]; ok {
+		n, err := parseInt64Param(paramMaxRetries, v)
+		if err != nil {
+			return nil, err
+		}
+		maxRetries = n
+	}
+	iops := int64(0)
+	if v, ok := parameters[paramIOPS]; ok {
+		n, err := parseInt64Param(paramIOPS, v)
+		if err != nil {
+			return nil, err
+		}
+		iops = n
+	}
+	retry := newRetryPolicy(retryDelay, int(maxRetries), maxRetryDelay, int(iops))
 
-func (client *accountSASBlobClient) GetContainerReference() *azure.Container {
-	return client.client.GetContainerReference(client.container)
-}
+	ignoreNotFound := paramBool(parameters, paramIgnoreNotFound, false)
 
-func (client *accountSASBlobClient) GetURLFor(blob *azure.Blob, expires time.Time) (url string, err error) {
-	// we just append the accountSAS token and ignore the expiry
-	blobUrl := blob.GetURL()
-	return blobUrl + "?" + client.accountSASToken, nil
-}
+	var client blobClient
+	switch {
+	case paramString(parameters, paramConnectionString, "") != "":
+		client, err = newConnectionStringClient(paramString(parameters, paramConnectionString, ""), containerName)
 
-func (client *accountSASBlobClient) GetCopySourceURL(blob *azure.Blob) (url string) {
-	blobUrl := blob.GetURL()
-	return blobUrl + "?" + client.accountSASToken
-}
+	case paramBool(parameters, paramUseDefaultCredential, false):
+		if serviceURL == "" {
+			return nil, fmt.Errorf("%s is required when %s is true", paramServiceURL, paramUseDefaultCredential)
+		}
+		client, err = newDefaultCredentialClient(serviceURL, containerName)
 
-type driver struct {
-	client        blobClient
-	container     string
-	rootDirectory string
-}
+	case paramString(parameters, paramTenantID, "") != "":
+		if serviceURL == "" {
+			return nil, fmt.Errorf("%s is required for service principal authentication", paramServiceURL)
+		}
+		clientID := paramString(parameters, paramClientID, "")
+		clientSecret := paramString(parameters, paramClientSecret, "")
+		if clientID == "" || clientSecret == "" {
+			return nil, fmt.Errorf("%s and %s are required for service principal authentication", paramClientID, paramClientSecret)
+		}
+		client, err = newServicePrincipalClient(serviceURL, paramString(parameters, paramTenantID, ""), clientID, clientSecret, containerName)
 
-type baseEmbed struct{ base.Base }
+	case paramBool(parameters, paramUseMSI, false):
+		if serviceURL == "" {
+			return nil, fmt.Errorf("%s is required when %s is true", paramServiceURL, paramUseMSI)
+		}
+		client, err = newMSIClient(serviceURL, paramString(parameters, paramMSIClientID, ""), containerName)
 
-// Driver is a storagedriver.StorageDriver implementation backed by
-// Microsoft Azure Blob Storage Service.
-type Driver struct{ baseEmbed }
+	default:
+		accountName := paramString(parameters, paramAccountName, "")
+		if accountName == "" {
+			return nil, fmt.Errorf("no %s parameter provided", paramAccountName)
+		}
+		accountKey := paramString(parameters, paramAccountKey, "")
+		if accountKey == "" {
+			return nil, fmt.Errorf("no %s parameter provided", paramAccountKey)
+		}
+		if serviceURL == "" {
+			realm := paramString(parameters, paramRealm, defaultRealm)
+			serviceURL = fmt.Sprintf("https://%s.blob.%s/", accountName, realm)
+		}
+		client, err = newAccountKeyClient(serviceURL, accountName, accountKey, containerName)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-func init() {
-	factory.Register(driverName, &azureDriverFactory{})
+	return newDriver(client, rootDirectory, int(chunkSize), maxConcurrency, tiers, useCRC64, retry, ignoreNotFound)
 }
 
-type azureDriverFactory struct{}
-
-func (factory *azureDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
-	return FromParameters(parameters)
+// paramString returns parameters[name] as a string, or def if it is unset
+// or empty.
+func paramString(parameters map[string]interface{}, name, def string) string {
+	v, ok := parameters[name]
+	if !ok || fmt.Sprint(v) == "" {
+		return def
+	}
+	return fmt.Sprint(v)
 }
 
-// FromParameters constructs a new Driver with a given parameters map.
-func FromParameters(parameters map[string]interface{}) (*Driver, error) {
-	rootDirectory, ok := parameters[paramRootDirectory]
+// paramBool returns parameters[name] coerced to a bool, or def if it is
+// unset.
+func paramBool(parameters map[string]interface{}, name string, def bool) bool {
+	v, ok := parameters[name]
 	if !ok {
-		rootDirectory = ""
-	}
-
-	container, ok := parameters[paramContainer]
-	if !ok || fmt.Sprint(container) == "" {
-		return nil, fmt.Errorf("no %s parameter provided", paramContainer)
+		return def
 	}
-
-	connectionString, ok := parameters[paramConnectionString]
-	if ok && fmt.Sprint(connectionString) != "" {
-		// Create a connection string based client
-		client, err := NewAccountSASClient(fmt.Sprint(connectionString), fmt.Sprint(container))
+	switch vv := v.(type) {
+	case bool:
+		return vv
+	case string:
+		b, err := strconv.ParseBool(vv)
 		if err != nil {
-			return nil, err
-		}
-		return NewFromClient(client, fmt.Sprint(container), fmt.Sprint(rootDirectory))
-	} else {
-		// else look for accountname, accountkey and realm
-		accountName, ok := parameters[paramAccountName]
-		if !ok || fmt.Sprint(accountName) == "" {
-			return nil, fmt.Errorf("no %s parameter provided", paramAccountName)
+			return def
 		}
+		return b
+	default:
+		return def
+	}
+}
 
-		accountKey, ok := parameters[paramAccountKey]
-		if !ok || fmt.Sprint(accountKey) == "" {
-			return nil, fmt.Errorf("no %s parameter provided", paramAccountKey)
+// parseInt64Param coerces a driver parameter, which may arrive as a string
+// or any integer kind depending on how the configuration was loaded, into
+// an int64.
+func parseInt64Param(name string, value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case string:
+		vv, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s parameter must be an integer, %v invalid", name, value)
 		}
-
-		realm, ok := parameters[paramRealm]
-		if !ok || fmt.Sprint(realm) == "" {
-			realm = azure.DefaultBaseURL
+		return vv, nil
+	case int64:
+		return v, nil
+	case int, int8, int16, int32, uint, uint8, uint16, uint32, uint64:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(int64(0))).Int(), nil
+	default:
+		return 0, fmt.Errorf("invalid value for %s: %#v", name, value)
+	}
+}
+
+// parseDurationParam coerces a driver parameter into a time.Duration. A
+// string is parsed with time.ParseDuration (e.g. "500ms", "2m"); any integer
+// kind is treated as a whole number of seconds.
+func parseDurationParam(name string, value interface{}) (time.Duration, error) {
+	if s, ok := value.(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
 		}
-
-		return New(fmt.Sprint(accountName), fmt.Sprint(accountKey), fmt.Sprint(container), fmt.Sprint(realm), fmt.Sprint(rootDirectory))
 	}
+	secs, err := parseInt64Param(name, value)
+	if err != nil {
+		return 0, fmt.Errorf("%s parameter must be a duration (e.g. \"2m\") or a number of seconds: %v", name, err)
+	}
+	return time.Duration(secs) * time.Second, nil
 }
 
-// New constructs a new Driver with the given Azure Storage Account credentials
-func NewFromClient(client blobClient, container, rootDirectory string) (*Driver, error) {
-	if _, err := client.CreateContainer(); err != nil {
-		return nil, err
+// NewFromClient constructs a new Driver using the given blobClient, storing
+// blobs under rootDirectory. Writes are staged in chunkSize blocks, up to
+// maxConcurrency of which may be in flight at once.
+func NewFromClient(client blobClient, rootDirectory string, chunkSize int, maxConcurrency uint64) (*Driver, error) {
+	if chunkSize < minChunkSize || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunkSize must be between %d and %d bytes, got %d", minChunkSize, maxChunkSize, chunkSize)
 	}
-
-	d := &driver{
-		client:        client,
-		container:     container,
-		rootDirectory: rootDirectory}
-	return &Driver{baseEmbed: baseEmbed{Base: base.Base{StorageDriver: d}}}, nil
+	if maxConcurrency < 1 {
+		return nil, fmt.Errorf("maxConcurrency must be positive, got %d", maxConcurrency)
+	}
+	return newDriver(client, rootDirectory, chunkSize, maxConcurrency, tierPolicy{}, false, newRetryPolicy(defaultRetryDelay, defaultMaxRetries, defaultMaxRetryDelay, 0), false)
 }
 
-// New constructs a new Driver with the given Azure Storage Account credentials
-func New(accountName, accountKey, container, realm, rootDirectory string) (*Driver, error) {
-	api, err := azure.NewClient(accountName, accountKey, realm, azure.DefaultAPIVersion, true)
+// New constructs a new Driver with the given Azure Storage Account
+// credentials, talking to the given realm (e.g. core.windows.net).
+func New(accountName, accountKey, container, realm, rootDirectory string, chunkSize int, maxConcurrency uint64) (*Driver, error) {
+	serviceURL := fmt.Sprintf("https://%s.blob.%s/", accountName, realm)
+	client, err := newAccountKeyClient(serviceURL, accountName, accountKey, container)
 	if err != nil {
 		return nil, err
 	}
+	return NewFromClient(client, rootDirectory, chunkSize, maxConcurrency)
+}
 
-	blobService := api.GetBlobService()
-	client := &simpleBlobClient{
-		client:    &blobService,
-		container: container,
+func newDriver(client blobClient, rootDirectory string, chunkSize int, maxConcurrency uint64, tiers tierPolicy, useCRC64 bool, retry retryPolicy, ignoreNotFound bool) (*Driver, error) {
+	d := &driver{
+		client:         client,
+		rootDirectory:  rootDirectory,
+		chunkSize:      chunkSize,
+		maxConcurrency: maxConcurrency,
+		tiers:          tiers,
+		useCRC64:       useCRC64,
+		retry:          retry,
+		ignoreNotFound: ignoreNotFound,
+	}
+	if err := d.retry.do(context.Background(), func(ctx context.Context) error {
+		return client.CreateContainer(ctx)
+	}); err != nil {
+		return nil, err
 	}
-
-	return NewFromClient(client, container, rootDirectory)
+	return &Driver{baseEmbed: baseEmbed{Base: base.Base{StorageDriver: d}}}, nil
 }
 
 // Implement the storagedriver.StorageDriver interface.
@@ -235,17 +369,39 @@ func (d *driver) Name() string {
 
 // GetContent retrieves the content stored at "path" as a []byte.
 func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
-	blobRef := d.client.GetBlobReference(d.blobPath(path))
-	blob, err := blobRef.Get(nil)
+	blobRef := d.client.BlockBlobClient(d.blobPath(path))
+	var resp blob.DownloadStreamResponse
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = blobRef.DownloadStream(ctx, nil)
+		return err
+	})
 	if err != nil {
-		if is404(err) {
+		if bloberror.HasCode(err, bloberror.BlobArchived) {
+			// Find out whether a rehydration is already pending before
+			// kicking off another one.
+			var props blob.GetPropertiesResponse
+			_ = d.retry.do(ctx, func(ctx context.Context) error {
+				var err error
+				props, err = blobRef.GetProperties(ctx, nil)
+				return err
+			})
+			return nil, d.rehydrate(ctx, d.blobPath(path), props.ArchiveStatus)
+		}
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
 			return nil, storagedriver.PathNotFoundError{Path: path}
 		}
 		return nil, err
 	}
-
-	defer blob.Close()
-	return ioutil.ReadAll(blob)
+	defer resp.Body.Close()
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyContentMD5(path, contents, resp.ContentMD5); err != nil {
+		return nil, err
+	}
+	return contents, nil
 }
 
 // PutContent stores the []byte content at a location designated by "path".
@@ -268,113 +424,175 @@ func (d *driver) PutContent(ctx context.Context, path string, contents []byte) e
 	// losing the existing data while migrating it to BlockBlob type. However,
 	// expectation is the clients pushing will be retrying when they get an error
 	// response.
-	blobRef := d.client.GetBlobReference(d.blobPath(path))
-	err := blobRef.GetProperties(nil)
-	if err != nil && !is404(err) {
+	blobRef := d.client.BlockBlobClient(d.blobPath(path))
+	var props blob.GetPropertiesResponse
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		props, err = blobRef.GetProperties(ctx, nil)
+		return err
+	})
+	switch {
+	case err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound):
 		return fmt.Errorf("failed to get blob properties: %v", err)
-	}
-	if err == nil && blobRef.Properties.BlobType != azure.BlobTypeBlock {
-		if err := blobRef.Delete(nil); err != nil {
-			return fmt.Errorf("failed to delete legacy blob (%s): %v", blobRef.Properties.BlobType, err)
+	case err == nil && props.BlobType != nil && *props.BlobType != blob.BlobTypeBlockBlob:
+		if err := d.retry.do(ctx, func(ctx context.Context) error {
+			_, err := blobRef.Delete(ctx, nil)
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to delete legacy blob (%s): %v", *props.BlobType, err)
 		}
 	}
 
-	r := bytes.NewReader(contents)
-	// reset properties to empty before doing overwrite
-	blobRef.Properties = azure.BlobProperties{}
-	return blobRef.CreateBlockBlobFromReader(r, nil)
+	opts := &blockblob.UploadBufferOptions{
+		AccessTier: tierPtr(d.tiers.tierFor(d.blobPath(path))),
+	}
+	if d.useCRC64 {
+		opts.TransactionalValidation = blob.TransferValidationTypeComputeCRC64()
+	} else {
+		sum := md5.Sum(contents)
+		opts.HTTPHeaders = &blob.HTTPHeaders{BlobContentMD5: sum[:]}
+	}
+
+	return d.retry.do(ctx, func(ctx context.Context) error {
+		_, err := blobRef.UploadBuffer(ctx, contents, opts)
+		return err
+	})
 }
 
 // Reader retrieves an io.ReadCloser for the content stored at "path" with a
 // given byte offset.
 func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
-	blobRef := d.client.GetBlobReference(d.blobPath(path))
-	if ok, err := blobRef.Exists(); err != nil {
+	blobRef := d.client.BlockBlobClient(d.blobPath(path))
+	var props blob.GetPropertiesResponse
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		props, err = blobRef.GetProperties(ctx, nil)
+		return err
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, storagedriver.PathNotFoundError{Path: path}
+		}
 		return nil, err
-	} else if !ok {
-		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	if blobIsArchived(props.AccessTier, props.ArchiveStatus) {
+		return nil, d.rehydrate(ctx, d.blobPath(path), props.ArchiveStatus)
 	}
 
-	err := blobRef.GetProperties(nil)
-	if err != nil {
-		return nil, err
+	var size int64
+	if props.ContentLength != nil {
+		size = *props.ContentLength
 	}
-	info := blobRef.Properties
-	size := info.ContentLength
 	if offset >= size {
-		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+		return io.NopCloser(bytes.NewReader(nil)), nil
 	}
 
-	resp, err := blobRef.GetRange(&azure.GetBlobRangeOptions{
-		Range: &azure.BlobRange{
-			Start: uint64(offset),
-			End:   0,
-		},
+	var resp blob.DownloadStreamResponse
+	err = d.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = blobRef.DownloadStream(ctx, &blob.DownloadStreamOptions{
+			Range: blob.HTTPRange{Offset: offset},
+		})
+		return err
 	})
 	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobArchived) {
+			return nil, d.rehydrate(ctx, d.blobPath(path), props.ArchiveStatus)
+		}
 		return nil, err
 	}
-	return resp, nil
+	if offset == 0 {
+		return newVerifyingReadCloser(resp.Body, path, resp.ContentMD5), nil
+	}
+	return resp.Body, nil
 }
 
 // Writer returns a FileWriter which will store the content written to it
 // at the location designated by "path" after the call to Commit.
-func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
-	blobRef := d.client.GetBlobReference(d.blobPath(path))
-	blobExists, err := blobRef.Exists()
-	if err != nil {
+func (d *driver) Writer(ctx context.Context, path string, appendMode bool) (storagedriver.FileWriter, error) {
+	blobRef := d.client.BlockBlobClient(d.blobPath(path))
+	var props blob.GetPropertiesResponse
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		props, err = blobRef.GetProperties(ctx, nil)
+		return err
+	})
+	blobExists := err == nil
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
 		return nil, err
 	}
+
 	var size int64
+	var blockIDs []string
 	if blobExists {
-		if append {
-			err = blobRef.GetProperties(nil)
+		if appendMode {
+			if props.ContentLength != nil {
+				size = *props.ContentLength
+			}
+
+			// Fetch the block list already committed to this blob so that
+			// appended data is staged after it rather than overwriting it.
+			var list blockblob.GetBlockListResponse
+			err := d.retry.do(ctx, func(ctx context.Context) error {
+				var err error
+				list, err = blobRef.GetBlockList(ctx, blockblob.BlockListTypeCommitted, nil)
+				return err
+			})
 			if err != nil {
 				return nil, err
 			}
-			blobProperties := blobRef.Properties
-			size = blobProperties.ContentLength
+			for _, b := range list.CommittedBlocks {
+				blockIDs = append(blockIDs, *b.Name)
+			}
 		} else {
-			err = blobRef.Delete(nil)
-			if err != nil {
+			if err := d.retry.do(ctx, func(ctx context.Context) error {
+				_, err := blobRef.Delete(ctx, nil)
+				return err
+			}); err != nil {
 				return nil, err
 			}
 		}
-	} else {
-		if append {
-			return nil, storagedriver.PathNotFoundError{Path: path}
-		}
-		err = blobRef.PutAppendBlob(nil)
-		if err != nil {
-			return nil, err
-		}
+	} else if appendMode {
+		return nil, storagedriver.PathNotFoundError{Path: path}
 	}
 
-	return d.newWriter(d.blobPath(path), size), nil
+	return d.newWriter(d.blobPath(path), size, blockIDs, d.tiers.tierFor(d.blobPath(path)), d.useCRC64, d.retry), nil
 }
 
 // Stat retrieves the FileInfo for the given path, including the current size
 // in bytes and the creation time.
 func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
 	blobPath := d.blobPath(path)
-	blobRef := d.client.GetBlobReference(blobPath)
-	// Check if the path is a blob
-	if ok, err := blobRef.Exists(); err != nil {
-		return nil, err
-	} else if ok {
-		err = blobRef.GetProperties(nil)
-		if err != nil {
-			return nil, err
+	blobRef := d.client.BlockBlobClient(blobPath)
+	var props blob.GetPropertiesResponse
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		props, err = blobRef.GetProperties(ctx, nil)
+		return err
+	})
+	if err == nil {
+		if blobIsArchived(props.AccessTier, props.ArchiveStatus) {
+			return nil, d.rehydrate(ctx, blobPath, props.ArchiveStatus)
 		}
-		blobProperties := blobRef.Properties
 
+		var size int64
+		if props.ContentLength != nil {
+			size = *props.ContentLength
+		}
+		var modTime time.Time
+		if props.LastModified != nil {
+			modTime = *props.LastModified
+		}
 		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
 			Path:    path,
-			Size:    blobProperties.ContentLength,
-			ModTime: time.Time(blobProperties.LastModified),
+			Size:    size,
+			ModTime: modTime,
 			IsDir:   false,
 		}}, nil
 	}
+	if !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, err
+	}
 
 	// Check if path is a virtual container
 	virtContainerPath := blobPath
@@ -382,20 +600,28 @@ func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo,
 		virtContainerPath += "/"
 	}
 
-	containerRef := d.client.GetContainerReference()
-	blobs, err := containerRef.ListBlobs(azure.ListBlobsParameters{
-		Prefix:     virtContainerPath,
-		MaxResults: 1,
+	maxResults := int32(1)
+	pager := d.client.ContainerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:     &virtContainerPath,
+		MaxResults: &maxResults,
 	})
-	if err != nil {
-		return nil, err
-	}
-	if len(blobs.Blobs) > 0 {
-		// path is a virtual container
-		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
-			Path:  path,
-			IsDir: true,
-		}}, nil
+	if pager.More() {
+		var page container.ListBlobsFlatResponse
+		err := d.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			page, err = pager.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Segment.BlobItems) > 0 {
+			// path is a virtual container
+			return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+				Path:  path,
+				IsDir: true,
+			}}, nil
+		}
 	}
 
 	// path is not a blob or virtual container
@@ -409,7 +635,7 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 		path = ""
 	}
 
-	blobs, err := d.listBlobs(d.container, path)
+	blobs, err := d.listBlobs(ctx, path)
 	if err != nil {
 		return blobs, err
 	}
@@ -424,48 +650,83 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 // Move moves an object stored at sourcePath to destPath, removing the original
 // object.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
-	srcBlobRef := d.client.GetBlobReference(d.blobPath(sourcePath))
-	sourceBlobURL := d.client.GetCopySourceURL(srcBlobRef)
-	destBlobRef := d.client.GetBlobReference(d.blobPath(destPath))
-	err := destBlobRef.Copy(sourceBlobURL, nil)
+	srcBlobRef := d.client.BlockBlobClient(d.blobPath(sourcePath))
+	destBlobRef := d.client.BlockBlobClient(d.blobPath(destPath))
+
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		_, err := destBlobRef.StartCopyFromURL(ctx, srcBlobRef.URL(), nil)
+		return err
+	})
 	if err != nil {
-		if is404(err) {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
 			return storagedriver.PathNotFoundError{Path: sourcePath}
 		}
 		return err
 	}
 
-	return srcBlobRef.Delete(nil)
+	// StartCopyFromURL is asynchronous; for a same-account copy it usually
+	// completes almost immediately, so poll until it does. deadline bounds
+	// the whole poll: large or cross-region copies are documented as
+	// possibly slow, but this guards against polling forever on a copy that
+	// never reaches a terminal status.
+	deadline := time.Now().Add(copyPollTimeout)
+	for {
+		var props blob.GetPropertiesResponse
+		err := d.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			props, err = destBlobRef.GetProperties(ctx, nil)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if props.CopyStatus == nil || *props.CopyStatus != blob.CopyStatusTypePending {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("azure: copy of %s to %s did not complete within %s", sourcePath, destPath, copyPollTimeout)
+		}
+		select {
+		case <-time.After(copyPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return d.retry.do(ctx, func(ctx context.Context) error {
+		_, err := srcBlobRef.Delete(ctx, nil)
+		return err
+	})
 }
 
 // Delete recursively deletes all objects stored at "path" and its subpaths.
 func (d *driver) Delete(ctx context.Context, path string) error {
-	blobRef := d.client.GetBlobReference(d.blobPath(path))
-	ok, err := blobRef.DeleteIfExists(nil)
-	if err != nil {
+	blobRef := d.client.BlockBlobClient(d.blobPath(path))
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		_, err := blobRef.Delete(ctx, nil)
 		return err
-	}
-	if ok {
+	})
+	if err == nil {
 		return nil // was a blob and deleted, return
 	}
+	if !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return err
+	}
 
 	// Not a blob, see if path is a virtual container with blobs
-	blobs, err := d.listBlobs(d.container, path)
+	blobs, err := d.listBlobs(ctx, path)
 	if err != nil {
 		return err
 	}
-
-	for _, b := range blobs {
-		blobRef = d.client.GetBlobReference(d.blobPath(b))
-		if err = blobRef.Delete(nil); err != nil {
-			return err
-		}
-	}
-
 	if len(blobs) == 0 {
 		return storagedriver.PathNotFoundError{Path: path}
 	}
-	return nil
+
+	paths := make([]string, len(blobs))
+	for i, b := range blobs {
+		paths[i] = d.blobPath(b)
+	}
+	return d.deleteBlobs(ctx, paths, d.ignoreNotFound)
 }
 
 // URLFor returns a publicly accessible URL for the blob stored at given path
@@ -480,8 +741,13 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 			expiresTime = t
 		}
 	}
-	blobRef := d.client.GetBlobReference(d.blobPath(path))
-	return d.client.GetURLFor(blobRef, expiresTime)
+	var url string
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		url, err = d.client.BlobSASURL(d.blobPath(path), expiresTime)
+		return err
+	})
+	return url, err
 }
 
 // Walk traverses a filesystem defined within driver, starting
@@ -524,7 +790,7 @@ func directDescendants(blobs []string, prefix string) []string {
 	return keys
 }
 
-func (d *driver) listBlobs(container, virtPath string) ([]string, error) {
+func (d *driver) listBlobs(ctx context.Context, virtPath string) ([]string, error) {
 	if virtPath != "" && !strings.HasSuffix(virtPath, "/") { // containerify the path
 		virtPath += "/"
 	}
@@ -540,27 +806,27 @@ func (d *driver) listBlobs(container, virtPath string) ([]string, error) {
 		prefix = "/"
 	}
 
+	fullPrefix := d.blobPath(virtPath)
 	out := []string{}
-	marker := ""
-	containerRef := d.client.GetContainerReference()
-	for {
-		resp, err := containerRef.ListBlobs(azure.ListBlobsParameters{
-			Marker: marker,
-			Prefix: d.blobPath(virtPath),
+	pager := d.client.ContainerClient().NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: &fullPrefix,
+	})
+	for pager.More() {
+		var page container.ListBlobsFlatResponse
+		err := d.retry.do(ctx, func(ctx context.Context) error {
+			var err error
+			page, err = pager.NextPage(ctx)
+			return err
 		})
-
 		if err != nil {
 			return out, err
 		}
-
-		for _, b := range resp.Blobs {
-			out = append(out, strings.Replace(b.Name, blobPrefix, prefix, 1))
-		}
-
-		if len(resp.Blobs) == 0 || resp.NextMarker == "" {
-			break
+		for _, b := range page.Segment.BlobItems {
+			if b.Name == nil {
+				continue
+			}
+			out = append(out, strings.Replace(*b.Name, blobPrefix, prefix, 1))
 		}
-		marker = resp.NextMarker
 	}
 	return out, nil
 }
@@ -568,103 +834,3 @@ func (d *driver) listBlobs(container, virtPath string) ([]string, error) {
 func (d *driver) blobPath(path string) string {
 	return strings.TrimLeft(strings.TrimRight(d.rootDirectory, "/")+path, "/")
 }
-
-func is404(err error) bool {
-	statusCodeErr, ok := err.(azure.AzureStorageServiceError)
-	return ok && statusCodeErr.StatusCode == http.StatusNotFound
-}
-
-type writer struct {
-	driver    *driver
-	path      string
-	size      int64
-	bw        *bufio.Writer
-	closed    bool
-	committed bool
-	cancelled bool
-}
-
-func (d *driver) newWriter(path string, size int64) storagedriver.FileWriter {
-	return &writer{
-		driver: d,
-		path:   path,
-		size:   size,
-		bw: bufio.NewWriterSize(&blockWriter{
-			client: d.client,
-			path:   path,
-		}, maxChunkSize),
-	}
-}
-
-func (w *writer) Write(p []byte) (int, error) {
-	if w.closed {
-		return 0, fmt.Errorf("already closed")
-	} else if w.committed {
-		return 0, fmt.Errorf("already committed")
-	} else if w.cancelled {
-		return 0, fmt.Errorf("already cancelled")
-	}
-
-	n, err := w.bw.Write(p)
-	w.size += int64(n)
-	return n, err
-}
-
-func (w *writer) Size() int64 {
-	return w.size
-}
-
-func (w *writer) Close() error {
-	if w.closed {
-		return fmt.Errorf("already closed")
-	}
-	w.closed = true
-	return w.bw.Flush()
-}
-
-func (w *writer) Cancel() error {
-	if w.closed {
-		return fmt.Errorf("already closed")
-	} else if w.committed {
-		return fmt.Errorf("already committed")
-	}
-	w.cancelled = true
-	blobRef := w.driver.client.GetBlobReference(w.path)
-	return blobRef.Delete(nil)
-}
-
-func (w *writer) Commit() error {
-	if w.closed {
-		return fmt.Errorf("already closed")
-	} else if w.committed {
-		return fmt.Errorf("already committed")
-	} else if w.cancelled {
-		return fmt.Errorf("already cancelled")
-	}
-	w.committed = true
-	return w.bw.Flush()
-}
-
-type blockWriter struct {
-	client blobClient
-	path   string
-}
-
-func (bw *blockWriter) Write(p []byte) (int, error) {
-	n := 0
-	blobRef := bw.client.GetBlobReference(bw.path)
-	for offset := 0; offset < len(p); offset += maxChunkSize {
-		chunkSize := maxChunkSize
-		if offset+chunkSize > len(p) {
-			chunkSize = len(p) - offset
-		}
-		err := blobRef.AppendBlock(p[offset:offset+chunkSize], nil)
-		if err != nil {
-			return n, err
-		}
-
-		n += chunkSize
-	}
-
-	return n, nil
-}
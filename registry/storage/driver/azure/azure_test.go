@@ -0,0 +1,135 @@
+package azure
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPutGetContent_RoundTrip exercises PutContent/GetContent end to end
+// against a fake server, covering the Track 2 client plumbing (request
+// signing, block-blob single-shot upload, download) introduced when the
+// driver moved off the deprecated SDK.
+func TestPutGetContent_RoundTrip(t *testing.T) {
+	const want = "hello from the track 2 sdk"
+	var stored []byte
+
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			if stored == nil {
+				w.Header().Set("x-ms-error-code", "BlobNotFound")
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("reading put body: %v", err)
+			}
+			stored = body
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			w.Write(stored)
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+	})
+
+	d := &driver{client: client, retry: fastRetry()}
+	if err := d.PutContent(context.Background(), "/path", []byte(want)); err != nil {
+		t.Fatalf("PutContent: %v", err)
+	}
+	got, err := d.GetContent(context.Background(), "/path")
+	if err != nil {
+		t.Fatalf("GetContent: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("GetContent = %q, want %q", got, want)
+	}
+}
+
+// TestMove_WaitsForPendingCopyThenDeletesSource exercises the happy path of
+// Move's copy-status poll loop.
+func TestMove_WaitsForPendingCopyThenDeletesSource(t *testing.T) {
+	var getPropsCalls, deletes int
+	var mu sync.Mutex
+
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodHead:
+			mu.Lock()
+			getPropsCalls++
+			n := getPropsCalls
+			mu.Unlock()
+			if n < 3 {
+				w.Header().Set("x-ms-copy-status", "pending")
+			}
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			mu.Lock()
+			deletes++
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+	})
+
+	d := &driver{client: client, retry: fastRetry()}
+	if err := d.Move(context.Background(), "/src", "/dst"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if getPropsCalls != 3 {
+		t.Fatalf("expected 3 GetProperties polls, got %d", getPropsCalls)
+	}
+	if deletes != 1 {
+		t.Fatalf("expected source to be deleted once, got %d", deletes)
+	}
+}
+
+// TestMove_ContextCancelledStopsPolling verifies that cancelling the caller's
+// context during a long-pending copy makes Move return promptly with the
+// context's error, instead of sleeping through cancellation.
+func TestMove_ContextCancelledStopsPolling(t *testing.T) {
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodHead:
+			// Always pending: only cancellation should end the poll.
+			w.Header().Set("x-ms-copy-status", "pending")
+			w.WriteHeader(http.StatusOK)
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+	})
+
+	d := &driver{client: client, retry: fastRetry()}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- d.Move(ctx, "/src", "/dst") }()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Move error = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Move did not return promptly after context cancellation")
+	}
+}
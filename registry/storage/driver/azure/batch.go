@@ -0,0 +1,95 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+const (
+	paramIgnoreNotFound = "ignorenotfound"
+
+	// maxBatchSize is the most sub-requests a single Blob Batch API call
+	// accepts.
+	maxBatchSize = 256
+	// batchParallelism bounds how many batches of up to maxBatchSize deletes
+	// are submitted concurrently.
+	batchParallelism = 4
+)
+
+// deleteBlobs deletes every blob in paths via the Blob Batch API,
+// maxBatchSize at a time, with up to batchParallelism batches in flight
+// concurrently. When ignoreNotFound is set, a blob that was already gone by
+// the time its batch ran is treated as deleted rather than as a failure.
+func (d *driver) deleteBlobs(ctx context.Context, paths []string, ignoreNotFound bool) error {
+	var batches [][]string
+	for len(paths) > 0 {
+		n := maxBatchSize
+		if n > len(paths) {
+			n = len(paths)
+		}
+		batches = append(batches, paths[:n])
+		paths = paths[n:]
+	}
+
+	sem := make(chan struct{}, batchParallelism)
+	var wg sync.WaitGroup
+	errs := make([]error, len(batches))
+	for i, batch := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, batch []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = d.deleteBatch(ctx, batch, ignoreNotFound)
+		}(i, batch)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("azure: %d of %d delete batches failed: %s", len(failed), len(batches), strings.Join(failed, "; "))
+}
+
+// deleteBatch submits a single Blob Batch delete request for paths. A
+// batch-level failure (a 503 covering the whole request, say) means none of
+// paths were deleted, so the whole batch is retried as a unit by d.retry
+// rather than per-blob. Once the batch is processed, any per-blob
+// sub-response failures are aggregated into a single error that still names
+// every blob that failed, so the caller can see partial progress.
+func (d *driver) deleteBatch(ctx context.Context, paths []string, ignoreNotFound bool) error {
+	var results []batchDeleteResult
+	err := d.retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		results, err = d.client.DeleteBatch(ctx, paths)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("batch of %d deletes failed: %v", len(paths), err)
+	}
+
+	var failed []string
+	for _, r := range results {
+		if r.err == nil {
+			continue
+		}
+		if ignoreNotFound && bloberror.HasCode(r.err, bloberror.BlobNotFound) {
+			continue
+		}
+		failed = append(failed, fmt.Sprintf("%s: %v", r.path, r.err))
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d deletes failed: %s", len(failed), len(paths), strings.Join(failed, "; "))
+}
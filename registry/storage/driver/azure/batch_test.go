@@ -0,0 +1,109 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// buildBatchResponse constructs a fake Blob Batch API multipart/mixed
+// response body, one part per status in statuses, in order, mirroring the
+// wire format azblob's internal/exported.ParseBlobBatchResponse expects:
+// each part is "application/http"/"binary" with a Content-ID matching the
+// sub-request's index and a raw HTTP status line as its body.
+func buildBatchResponse(statuses []int) (body []byte, contentType string) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for i, status := range statuses {
+		part, _ := w.CreatePart(map[string][]string{
+			"Content-Type":              {"application/http"},
+			"Content-Transfer-Encoding": {"binary"},
+			"Content-ID":                {fmt.Sprintf("%d", i)},
+		})
+		fmt.Fprintf(part, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+		if status == http.StatusNotFound {
+			fmt.Fprintf(part, "x-ms-error-code: BlobNotFound\r\n")
+		}
+		fmt.Fprintf(part, "x-ms-request-id: fake-request-id\r\n\r\n")
+	}
+	w.Close()
+	return buf.Bytes(), "multipart/mixed; boundary=" + w.Boundary()
+}
+
+func batchHandler(statuses []int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") != "batch" {
+			panic(fmt.Sprintf("unexpected request: %s %s", r.Method, r.URL))
+		}
+		body, contentType := buildBatchResponse(statuses)
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
+	}
+}
+
+// TestDeleteBlobs_PartialNotFoundRespectsIgnoreNotFound verifies that a
+// sub-response of BlobNotFound within an otherwise successful batch is
+// suppressed when ignoreNotFound is set, but still surfaced as a failure
+// when it isn't.
+func TestDeleteBlobs_PartialNotFoundRespectsIgnoreNotFound(t *testing.T) {
+	paths := []string{"a", "b", "c"}
+	statuses := []int{http.StatusAccepted, http.StatusNotFound, http.StatusAccepted}
+	client := newFakeServiceClient(t, batchHandler(statuses))
+	d := &driver{client: client, retry: fastRetry()}
+
+	if err := d.deleteBlobs(context.Background(), paths, true); err != nil {
+		t.Fatalf("deleteBlobs with ignoreNotFound=true: %v", err)
+	}
+
+	err := d.deleteBlobs(context.Background(), paths, false)
+	if err == nil {
+		t.Fatal("deleteBlobs with ignoreNotFound=false: expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "b: ") {
+		t.Fatalf("deleteBlobs error = %v, want it to name the failed blob %q", err, "b")
+	}
+}
+
+// TestDeleteBlobs_WholeBatchFailureRetriedAsUnit verifies that a 503
+// covering the entire SubmitBatch call (not a per-blob sub-response) is
+// retried as a single unit by d.retry, rather than dropping any deletes.
+func TestDeleteBlobs_WholeBatchFailureRetriedAsUnit(t *testing.T) {
+	paths := []string{"a", "b"}
+	var attempts int
+	var mu sync.Mutex
+
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") != "batch" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+		if n < 3 {
+			serviceUnavailable(w)
+			return
+		}
+		body, contentType := buildBatchResponse([]int{http.StatusAccepted, http.StatusAccepted})
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
+	})
+
+	d := &driver{client: client, retry: fastRetry()}
+	if err := d.deleteBlobs(context.Background(), paths, false); err != nil {
+		t.Fatalf("deleteBlobs: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Fatalf("expected the whole batch to be retried as a unit (3 attempts), got %d", attempts)
+	}
+}
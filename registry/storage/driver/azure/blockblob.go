@@ -0,0 +1,240 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"sync"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+)
+
+// writer is a storagedriver.FileWriter that stages data to an Azure block
+// blob via StageBlock and only makes it visible on Commit, via
+// CommitBlockList. Staging happens chunkSize bytes at a time, with up to
+// maxConcurrency chunks in flight concurrently.
+type writer struct {
+	client         blobClient
+	path           string
+	chunkSize      int
+	maxConcurrency uint64
+	tier           blob.AccessTier
+	useCRC64       bool
+	retry          retryPolicy
+
+	size int64
+	// blockIDs holds the ordered, base64-encoded IDs of the blocks,
+	// committed and uncommitted, that make up the blob so far. Appended
+	// writers seed this from the blob's existing committed block list.
+	blockIDs []string
+	// nextBlockNum is the sequence number to assign to the next staged
+	// block; it determines the block's deterministic ID.
+	nextBlockNum int
+
+	// blobHash streams an MD5 over every byte written, so Commit can set
+	// the blob-level Content-MD5 header. It is nil for append writers
+	// (Content-MD5 covers the whole blob, and we don't have the prior
+	// bytes to hash) and when useCRC64 opts out of the CPU cost.
+	blobHash hash.Hash
+
+	// pending buffers bytes smaller than chunkSize between Write calls.
+	pending []byte
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (d *driver) newWriter(path string, size int64, blockIDs []string, tier blob.AccessTier, useCRC64 bool, retry retryPolicy) storagedriver.FileWriter {
+	w := &writer{
+		client:         d.client,
+		path:           path,
+		chunkSize:      d.chunkSize,
+		maxConcurrency: d.maxConcurrency,
+		tier:           tier,
+		useCRC64:       useCRC64,
+		retry:          retry,
+		size:           size,
+		blockIDs:       blockIDs,
+		nextBlockNum:   len(blockIDs),
+	}
+	if len(blockIDs) == 0 && !useCRC64 {
+		w.blobHash = md5.New()
+	}
+	return w
+}
+
+// blockID deterministically derives a base64-encoded block ID from a
+// sequence number, so that retried or resumed writers stage the same block
+// under the same identity.
+func blockID(n int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%032d", n)))
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	if w.blobHash != nil {
+		w.blobHash.Write(p)
+	}
+
+	w.pending = append(w.pending, p...)
+	for len(w.pending) >= w.chunkSize {
+		if err := w.stageChunks(context.Background(), w.pending, false); err != nil {
+			return 0, err
+		}
+	}
+
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+// stageChunks splits buf into chunkSize pieces (the final piece may be
+// shorter, unless final is false, in which case a trailing partial chunk is
+// left buffered for the next Write or for Commit) and stages them
+// concurrently, bounded by maxConcurrency in-flight requests at a time.
+func (w *writer) stageChunks(ctx context.Context, buf []byte, final bool) error {
+	type chunk struct {
+		num  int
+		data []byte
+	}
+
+	var chunks []chunk
+	consumed := 0
+	for offset := 0; offset < len(buf); offset += w.chunkSize {
+		end := offset + w.chunkSize
+		if end > len(buf) {
+			if !final {
+				break
+			}
+			end = len(buf)
+		}
+		chunks = append(chunks, chunk{num: w.nextBlockNum, data: buf[offset:end]})
+		w.nextBlockNum++
+		consumed = end
+	}
+	w.pending = append([]byte(nil), buf[consumed:]...)
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+
+	sem := make(chan struct{}, w.maxConcurrency)
+	var wg sync.WaitGroup
+	blobRef := w.client.BlockBlobClient(w.path)
+	for i, c := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, c chunk) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			id := blockID(c.num)
+			opts := &blockblob.StageBlockOptions{
+				TransactionalValidation: transactionalValidation(w.useCRC64, c.data),
+			}
+			err := w.retry.do(ctx, func(ctx context.Context) error {
+				_, err := blobRef.StageBlock(ctx, id, newReadSeekCloser(c.data), opts)
+				return err
+			})
+			if err != nil {
+				errs[i] = fmt.Errorf("staging block %d: %v", c.num, err)
+				return
+			}
+			ids[i] = id
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	w.blockIDs = append(w.blockIDs, ids...)
+	return nil
+}
+
+func (w *writer) Size() int64 {
+	return w.size
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+	return nil
+}
+
+func (w *writer) Cancel() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+	blobRef := w.client.BlockBlobClient(w.path)
+	return w.retry.do(context.Background(), func(ctx context.Context) error {
+		_, err := blobRef.Delete(ctx, nil)
+		return err
+	})
+}
+
+func (w *writer) Commit() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	w.committed = true
+
+	ctx := context.Background()
+	if len(w.pending) > 0 {
+		if err := w.stageChunks(ctx, w.pending, true); err != nil {
+			return err
+		}
+	}
+
+	opts := &blockblob.CommitBlockListOptions{
+		Tier: tierPtr(w.tier),
+	}
+	if w.blobHash != nil {
+		opts.HTTPHeaders = &blob.HTTPHeaders{BlobContentMD5: w.blobHash.Sum(nil)}
+	}
+
+	blobRef := w.client.BlockBlobClient(w.path)
+	return w.retry.do(ctx, func(ctx context.Context) error {
+		_, err := blobRef.CommitBlockList(ctx, w.blockIDs, opts)
+		return err
+	})
+}
+
+// readSeekCloser adapts a []byte into the io.ReadSeekCloser StageBlock
+// requires, without copying the backing array.
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func newReadSeekCloser(p []byte) readSeekCloser {
+	return readSeekCloser{bytes.NewReader(p)}
+}
+
+func (readSeekCloser) Close() error { return nil }
@@ -0,0 +1,161 @@
+package azure
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fastRetry is a retryPolicy tuned for tests: it still retries and respects
+// ctx, but without the default backoff's real-world delays.
+func fastRetry() retryPolicy {
+	return newRetryPolicy(time.Millisecond, 5, 20*time.Millisecond, 0)
+}
+
+func serviceUnavailable(w http.ResponseWriter) {
+	w.Header().Set("x-ms-error-code", "ServerBusy")
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+// committedBlockList decodes just the Latest block IDs out of a
+// CommitBlockList request body, ignoring the root element name the SDK
+// chose for the BlockLookupList it marshaled.
+type committedBlockList struct {
+	Latest []string `xml:"Latest"`
+}
+
+func TestWriterCommit_RetriesInterruptedCommit(t *testing.T) {
+	var attempts int
+	var mu sync.Mutex
+
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") != "blocklist" || r.Method != http.MethodPut {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+		if n < 3 {
+			serviceUnavailable(w)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	d := &driver{client: client, retry: fastRetry()}
+	w := d.newWriter("blob", 0, nil, "", false, d.retry)
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Fatalf("expected CommitBlockList to be attempted 3 times, got %d", attempts)
+	}
+}
+
+func TestWriterCommit_ResumedAppendPreservesBlockOrder(t *testing.T) {
+	existing := []string{blockID(0), blockID(1)}
+
+	var staged []string
+	var committedBody []byte
+	var mu sync.Mutex
+
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("comp") {
+		case "block":
+			mu.Lock()
+			staged = append(staged, r.URL.Query().Get("blockid"))
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		case "blocklist":
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("reading commit body: %v", err)
+			}
+			mu.Lock()
+			committedBody = body
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+	})
+
+	d := &driver{client: client, chunkSize: 4, maxConcurrency: 1, retry: fastRetry()}
+	// Simulates Writer(ctx, path, true) resuming a blob whose first two
+	// blocks were already committed by a previous writer.
+	w := d.newWriter("blob", 8, append([]string(nil), existing...), "", false, d.retry)
+	if _, err := w.Write([]byte("more")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(staged) != 1 {
+		t.Fatalf("expected exactly 1 new block staged, got %d: %v", len(staged), staged)
+	}
+	wantLatest := append(append([]string(nil), existing...), staged[0])
+
+	var got committedBlockList
+	if err := xml.Unmarshal(committedBody, &got); err != nil {
+		t.Fatalf("unmarshaling commit body: %v", err)
+	}
+	if strings.Join(got.Latest, ",") != strings.Join(wantLatest, ",") {
+		t.Fatalf("CommitBlockList order = %v, want %v (existing blocks must stay first)", got.Latest, wantLatest)
+	}
+}
+
+func TestWriterStageChunks_ConcurrentFailuresRetried(t *testing.T) {
+	seen := make(map[string]int)
+	var mu sync.Mutex
+
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") != "block" {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		id := r.URL.Query().Get("blockid")
+		mu.Lock()
+		seen[id]++
+		n := seen[id]
+		mu.Unlock()
+		// Every block fails its first attempt, so a correct retrying,
+		// concurrent writer must retry each one independently.
+		if n == 1 {
+			serviceUnavailable(w)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	d := &driver{client: client, chunkSize: 2, maxConcurrency: 4, retry: fastRetry()}
+	w := d.newWriter("blob", 0, nil, "", false, d.retry)
+	data := []byte("0123456789ABCDEF") // 8 blocks of 2 bytes at chunkSize=2
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 8 {
+		t.Fatalf("expected 8 distinct blocks staged, got %d", len(seen))
+	}
+	for id, n := range seen {
+		if n < 2 {
+			t.Fatalf("block %s was not retried after its injected failure (attempts=%d)", id, n)
+		}
+	}
+}
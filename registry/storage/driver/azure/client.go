@@ -0,0 +1,178 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+)
+
+// blobClient abstracts the azblob operations the driver needs, so the
+// account-key, connection-string, and Azure AD (azidentity) authenticated
+// paths can all drive the same driver implementation.
+type blobClient interface {
+	BlockBlobClient(path string) *blockblob.Client
+	ContainerClient() *container.Client
+	BlobSASURL(path string, expires time.Time) (string, error)
+	CreateContainer(ctx context.Context) error
+	DeleteBatch(ctx context.Context, paths []string) ([]batchDeleteResult, error)
+}
+
+// serviceClient is the blobClient used for every authentication mode. Only
+// shared-key backed clients (account key or an account-key connection
+// string) can mint a SAS URL locally, so canGenerateSAS records that.
+type serviceClient struct {
+	service        *azblob.Client
+	container      string
+	canGenerateSAS bool
+}
+
+func (c *serviceClient) ContainerClient() *container.Client {
+	return c.service.ServiceClient().NewContainerClient(c.container)
+}
+
+func (c *serviceClient) BlockBlobClient(path string) *blockblob.Client {
+	return c.ContainerClient().NewBlockBlobClient(path)
+}
+
+func (c *serviceClient) CreateContainer(ctx context.Context) error {
+	_, err := c.ContainerClient().Create(ctx, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return err
+	}
+	return nil
+}
+
+func (c *serviceClient) BlobSASURL(path string, expires time.Time) (string, error) {
+	if !c.canGenerateSAS {
+		return "", errors.New("azure: generating a SAS URL requires accountkey or a connection string with an account key; configure one of these, or obtain URLs another way, when using usedefaultcredential/tenantid/usemsi auth")
+	}
+	return c.BlockBlobClient(path).GetSASURL(sas.BlobPermissions{Read: true}, expires, nil)
+}
+
+// batchDeleteResult reports the outcome of a single delete sub-request
+// within a Blob Batch request: err is nil on success, or that blob's own
+// sub-response error (e.g. BlobNotFound) on failure.
+type batchDeleteResult struct {
+	path string
+	err  error
+}
+
+// DeleteBatch deletes every blob in paths in a single Blob Batch API
+// request (the Batch API caps a request at 256 sub-requests; callers are
+// responsible for chunking paths to that limit). A returned error means the
+// batch itself failed (e.g. a 503 covering every sub-request, or building
+// the request failed) and none of paths were necessarily deleted; a nil
+// error with failing entries in the returned slice means the batch was
+// processed and individual blobs failed on their own (most commonly
+// BlobNotFound).
+func (c *serviceClient) DeleteBatch(ctx context.Context, paths []string) ([]batchDeleteResult, error) {
+	svc := c.service.ServiceClient()
+	batch, err := svc.NewBatchBuilder()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if err := batch.Delete(c.container, path, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := svc.SubmitBatch(ctx, batch, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]batchDeleteResult, len(paths))
+	for i, path := range paths {
+		results[i].path = path
+		if i < len(resp.Responses) {
+			results[i].err = resp.Responses[i].Error
+		}
+	}
+	return results, nil
+}
+
+// newAccountKeyClient builds a blobClient authenticated with an Azure
+// Storage account name and key, talking to serviceURL (an account blob
+// endpoint, e.g. https://<account>.blob.core.windows.net/).
+func newAccountKeyClient(serviceURL, accountName, accountKey, container string) (*serviceClient, error) {
+	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, err
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &serviceClient{service: client, container: container, canGenerateSAS: true}, nil
+}
+
+// newConnectionStringClient builds a blobClient from an Azure Storage
+// connection string, which may carry either an account key or a SAS token.
+func newConnectionStringClient(connectionString, container string) (*serviceClient, error) {
+	client, err := azblob.NewClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		return nil, err
+	}
+	// A connection string built from an account key lets us sign SAS URLs
+	// locally; one built from a SAS token does not, but BlobSASURL will
+	// simply fail at call time in that case rather than here.
+	canGenerateSAS := strings.Contains(strings.ToLower(connectionString), "accountkey=")
+	return &serviceClient{service: client, container: container, canGenerateSAS: canGenerateSAS}, nil
+}
+
+// newDefaultCredentialClient builds a blobClient authenticated via
+// azidentity.DefaultAzureCredential, which tries managed identity,
+// workload identity, environment variables, and the Azure CLI in turn.
+func newDefaultCredentialClient(serviceURL, container string) (*serviceClient, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := azblob.NewClient(serviceURL, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &serviceClient{service: client, container: container}, nil
+}
+
+// newServicePrincipalClient builds a blobClient authenticated as an Azure
+// AD service principal via client secret.
+func newServicePrincipalClient(serviceURL, tenantID, clientID, clientSecret, container string) (*serviceClient, error) {
+	cred, err := azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := azblob.NewClient(serviceURL, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &serviceClient{service: client, container: container}, nil
+}
+
+// newMSIClient builds a blobClient authenticated via a managed identity.
+// msiClientID selects a user-assigned identity; leave it empty to use the
+// system-assigned identity.
+func newMSIClient(serviceURL, msiClientID, container string) (*serviceClient, error) {
+	opts := &azidentity.ManagedIdentityCredentialOptions{}
+	if msiClientID != "" {
+		opts.ID = azidentity.ClientID(msiClientID)
+	}
+	cred, err := azidentity.NewManagedIdentityCredential(opts)
+	if err != nil {
+		return nil, err
+	}
+	client, err := azblob.NewClient(serviceURL, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &serviceClient{service: client, container: container}, nil
+}
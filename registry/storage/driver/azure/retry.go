@@ -0,0 +1,176 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+const (
+	paramRetryDelay    = "retrydelay"
+	paramMaxRetries    = "maxretries"
+	paramMaxRetryDelay = "maxretrydelay"
+	paramIOPS          = "iops"
+
+	// defaultRetryDelay is the base of the exponential backoff used between
+	// retries, before jitter.
+	defaultRetryDelay = 100 * time.Millisecond
+	// defaultMaxRetries bounds how many times a single Azure call is retried
+	// before its error is returned to the caller.
+	defaultMaxRetries = 10
+	// defaultMaxRetryDelay caps both the exponential backoff and any
+	// Retry-After Azure asks for, so a single call can't stall indefinitely.
+	defaultMaxRetryDelay = 2 * time.Minute
+)
+
+// retryPolicy wraps Azure calls with retry-with-backoff and, optionally,
+// pacing to stay under an account's request-rate budget. The zero value
+// makes a single attempt with no retries and does no pacing; its backoff
+// falls back to defaultRetryDelay/defaultMaxRetryDelay.
+type retryPolicy struct {
+	delay      time.Duration
+	maxRetries int
+	maxDelay   time.Duration
+	pacer      *pacer
+}
+
+func newRetryPolicy(delay time.Duration, maxRetries int, maxDelay time.Duration, iops int) retryPolicy {
+	return retryPolicy{delay: delay, maxRetries: maxRetries, maxDelay: maxDelay, pacer: newPacer(iops)}
+}
+
+type retryCountKey struct{}
+
+// withRetryCount annotates ctx with n, the number of retries attempted so
+// far for the in-flight call, so code that runs under the context handed to
+// the retried fn can observe it via RetryCount without an extra return
+// value. The caller of retryPolicy.do never sees the annotated context.
+func withRetryCount(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, retryCountKey{}, n)
+}
+
+// RetryCount returns the number of retries the azure driver has attempted
+// for the call associated with ctx, or 0 if none have occurred or ctx never
+// passed through the driver's retry helper.
+func RetryCount(ctx context.Context) int {
+	n, _ := ctx.Value(retryCountKey{}).(int)
+	return n
+}
+
+// do calls fn, retrying on retryable errors with exponential backoff and
+// full jitter, honoring any Retry-After Azure returns, up to p.maxRetries
+// retries (p.maxRetries+1 attempts in all). fn is given a context annotated
+// with the current attempt number.
+func (p retryPolicy) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		p.pacer.wait(ctx)
+		err = fn(withRetryCount(ctx, attempt))
+		if err == nil || attempt >= p.maxRetries || !isRetryable(err) {
+			return err
+		}
+		select {
+		case <-time.After(p.backoff(attempt, err)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff computes how long to wait before the next attempt: Azure's own
+// Retry-After when one was returned, otherwise exponential backoff with full
+// jitter, both capped at maxDelay.
+func (p retryPolicy) backoff(attempt int, err error) time.Duration {
+	max := p.maxDelay
+	if max <= 0 {
+		max = defaultMaxRetryDelay
+	}
+	if d, ok := retryAfter(err); ok {
+		if d > max {
+			return max
+		}
+		return d
+	}
+
+	delay := p.delay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	base := delay * time.Duration(math.Pow(2, float64(attempt)))
+	if base <= 0 || base > max {
+		base = max
+	}
+	return time.Duration(rand.Int63n(int64(base) + 1))
+}
+
+// retryAfter extracts Azure's Retry-After header from err, if err wraps an
+// azcore.ResponseError that carries one.
+func retryAfter(err error) (time.Duration, bool) {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.RawResponse == nil {
+		return 0, false
+	}
+	v := respErr.RawResponse.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// isRetryable reports whether err is worth retrying: a 429 (throttled),
+// 500, 502, 503 or 504 response from Azure, or a timeout/temporary network
+// error.
+func isRetryable(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary() //nolint:staticcheck // Temporary is deprecated upstream but still the only signal some transports give us
+	}
+	return false
+}
+
+// pacer throttles calls to approximately iops operations per second, so a
+// large registry doing many concurrent uploads doesn't trip Azure's
+// account-level throttling. A nil *pacer (the default, iops <= 0) paces
+// nothing.
+type pacer struct {
+	ticker *time.Ticker
+}
+
+func newPacer(iops int) *pacer {
+	if iops <= 0 {
+		return nil
+	}
+	return &pacer{ticker: time.NewTicker(time.Second / time.Duration(iops))}
+}
+
+func (p *pacer) wait(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	select {
+	case <-p.ticker.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,85 @@
+package azure
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// TestBackoff_RespectsRetryAfterHeader verifies that when Azure's response
+// carries a Retry-After header, the retry policy waits that long rather
+// than computing its own exponential backoff.
+func TestBackoff_RespectsRetryAfterHeader(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"Retry-After": []string{"3"}},
+		Body:       http.NoBody,
+	}
+	err := runtime.NewResponseError(resp)
+
+	p := newRetryPolicy(10*time.Millisecond, 5, time.Minute, 0)
+	if got := p.backoff(0, err); got != 3*time.Second {
+		t.Fatalf("backoff = %s, want 3s (the Retry-After value)", got)
+	}
+}
+
+// TestBackoff_CapsRetryAfterAtMaxDelay verifies a Retry-After longer than
+// the configured max delay is capped rather than honored outright, so a
+// single misbehaving response can't stall a caller indefinitely.
+func TestBackoff_CapsRetryAfterAtMaxDelay(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"Retry-After": []string{"120"}},
+		Body:       http.NoBody,
+	}
+	err := runtime.NewResponseError(resp)
+
+	p := newRetryPolicy(10*time.Millisecond, 5, 5*time.Second, 0)
+	if got := p.backoff(0, err); got != 5*time.Second {
+		t.Fatalf("backoff = %s, want the 5s cap", got)
+	}
+}
+
+// TestRetryPolicy_EventuallySucceedsAfter503s drives a real driver call
+// through a fault-injecting fake server that returns 503 with a Retry-After
+// header a few times before succeeding, and verifies the driver retries
+// until it does rather than surfacing the transient failure to the caller.
+func TestRetryPolicy_EventuallySucceedsAfter503s(t *testing.T) {
+	const contents = "eventually consistent"
+	var attempts int
+	var mu sync.Mutex
+
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+		if n < 3 {
+			w.Header().Set("Retry-After", "1")
+			w.Header().Set("x-ms-error-code", "ServerBusy")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, contents)
+	})
+
+	d := &driver{client: client, retry: newRetryPolicy(10*time.Millisecond, 5, 2*time.Second, 0)}
+	got, err := d.GetContent(context.Background(), "/path")
+	if err != nil {
+		t.Fatalf("GetContent: %v", err)
+	}
+	if string(got) != contents {
+		t.Fatalf("GetContent = %q, want %q", got, contents)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts (2 failures + 1 success), got %d", attempts)
+	}
+}
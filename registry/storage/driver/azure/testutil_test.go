@@ -0,0 +1,41 @@
+package azure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// fakeAccountKey is a syntactically valid (base64) shared key used only to
+// sign requests against the fake servers below; nothing ever validates it.
+const fakeAccountKey = "c3VwZXJzZWNyZXRmYWtlYWNjb3VudGtleQ=="
+
+// newFakeServiceClient starts an httptest.Server driven by handler and
+// returns a serviceClient wired to talk to it in place of a real storage
+// account, so driver code can be exercised against canned HTTP responses.
+// The SDK's own built-in retry policy is disabled so only the driver's
+// retryPolicy governs retries in tests that care about that distinction.
+func newFakeServiceClient(t *testing.T, handler http.HandlerFunc) *serviceClient {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	cred, err := azblob.NewSharedKeyCredential("account", fakeAccountKey)
+	if err != nil {
+		t.Fatalf("NewSharedKeyCredential: %v", err)
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(ts.URL, cred, &azblob.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			InsecureAllowCredentialWithHTTP: true,
+			Retry:                           policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClientWithSharedKeyCredential: %v", err)
+	}
+	return &serviceClient{service: client, container: "container", canGenerateSAS: true}
+}
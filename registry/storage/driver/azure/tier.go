@@ -0,0 +1,174 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+const (
+	paramDefaultTier       = "defaulttier"
+	paramTierOverrides     = "tieroverrides"
+	paramAutoRehydrate     = "autorehydrate"
+	paramRehydratePriority = "rehydratepriority"
+	paramRehydrateToTier   = "rehydratetotier"
+
+	// defaultRehydrateRetryAfter is the hint given to callers of a blob
+	// that is archived or being rehydrated out of Archive. Azure gives no
+	// exact ETA for rehydration; "within 15 hours" is Microsoft's own
+	// published expectation for the standard priority.
+	defaultRehydrateRetryAfter = 15 * time.Hour
+)
+
+// tierRule applies tier to any blob whose path has the given prefix.
+type tierRule struct {
+	prefix string
+	tier   blob.AccessTier
+}
+
+// tierPolicy decides which access tier PutContent and block-blob commits
+// should use, and how reads of an Archive-tier blob are handled.
+type tierPolicy struct {
+	// defaultTier is applied to every write unless a longer-matching
+	// override applies. Empty means leave it to Azure's own default.
+	defaultTier blob.AccessTier
+	// overrides are matched longest-prefix-wins, so a more specific path
+	// can be cold-stored independently of its parent's tier.
+	overrides []tierRule
+
+	// autoRehydrate, when set, issues a SetTier rehydration request the
+	// first time an Archive-tier blob is read. When unset, reads of
+	// Archive-tier blobs fail fast with ErrBlobRehydrating so the caller
+	// can decide whether to kick off rehydration itself.
+	autoRehydrate     bool
+	rehydratePriority blob.RehydratePriority
+	rehydrateToTier   blob.AccessTier
+}
+
+// tierFor returns the access tier that should be applied to a write at
+// path, or "" if none is configured (in which case Azure applies the
+// account's own default tier).
+func (p tierPolicy) tierFor(path string) blob.AccessTier {
+	best := p.defaultTier
+	bestLen := -1
+	for _, o := range p.overrides {
+		if strings.HasPrefix(path, o.prefix) && len(o.prefix) > bestLen {
+			best = o.tier
+			bestLen = len(o.prefix)
+		}
+	}
+	return best
+}
+
+// tierPtr returns a pointer to tier, or nil if tier is unset, matching the
+// azblob options structs that take a *blob.AccessTier.
+func tierPtr(tier blob.AccessTier) *blob.AccessTier {
+	if tier == "" {
+		return nil
+	}
+	return &tier
+}
+
+func parseAccessTier(name, value string) (blob.AccessTier, error) {
+	switch strings.ToLower(value) {
+	case "hot":
+		return blob.AccessTierHot, nil
+	case "cool":
+		return blob.AccessTierCool, nil
+	case "cold":
+		return blob.AccessTierCold, nil
+	case "archive":
+		return blob.AccessTierArchive, nil
+	default:
+		return "", fmt.Errorf("%s parameter must be one of Hot, Cool, Cold, or Archive, got %q", name, value)
+	}
+}
+
+// parseTierOverrides parses a ";"-separated list of "prefix=tier" entries,
+// e.g. "manifests/old=Cool;blobs/cold=Archive".
+func parseTierOverrides(value string) ([]tierRule, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var rules []tierRule
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		idx := strings.LastIndex(entry, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("%s entry %q must be of the form prefix=tier", paramTierOverrides, entry)
+		}
+		tier, err := parseAccessTier(paramTierOverrides, entry[idx+1:])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, tierRule{prefix: entry[:idx], tier: tier})
+	}
+	return rules, nil
+}
+
+func parseRehydratePriority(value string) (blob.RehydratePriority, error) {
+	switch strings.ToLower(value) {
+	case "", "standard":
+		return blob.RehydratePriorityStandard, nil
+	case "high":
+		return blob.RehydratePriorityHigh, nil
+	default:
+		return "", fmt.Errorf("%s parameter must be Standard or High, got %q", paramRehydratePriority, value)
+	}
+}
+
+// ErrBlobRehydrating is returned when a read targets a blob in the Archive
+// tier. Archive-tier blobs cannot be read directly; they must first be
+// rehydrated to Hot or Cool, which commonly takes hours. The registry
+// should surface this as a 503 with a Retry-After of RetryAfter rather
+// than a confusing read failure.
+type ErrBlobRehydrating struct {
+	Path       string
+	RetryAfter time.Duration
+}
+
+func (e ErrBlobRehydrating) Error() string {
+	return fmt.Sprintf("azure: %s is archived and must be rehydrated before it can be read; retry after %s", e.Path, e.RetryAfter)
+}
+
+// blobIsArchived reports whether a blob's properties indicate it is
+// currently in the Archive tier, including while a rehydration requested
+// out of Archive is still in progress.
+func blobIsArchived(accessTier, archiveStatus *string) bool {
+	if archiveStatus != nil && *archiveStatus != "" {
+		return true
+	}
+	return accessTier != nil && *accessTier == string(blob.AccessTierArchive)
+}
+
+// rehydrate handles a read against an Archive-tier blob at path.
+// archiveStatus is the blob's current ArchiveStatus property, or nil if the
+// caller doesn't have it to hand; a non-empty value means a rehydration is
+// already in progress. If auto-rehydration is enabled and no rehydration is
+// already pending, it kicks off a SetTier request at the configured
+// priority; either way it returns ErrBlobRehydrating so the caller fails
+// fast instead of blocking for hours.
+func (d *driver) rehydrate(ctx context.Context, path string, archiveStatus *string) error {
+	alreadyRehydrating := archiveStatus != nil && *archiveStatus != ""
+	if d.tiers.autoRehydrate && !alreadyRehydrating {
+		priority := d.tiers.rehydratePriority
+		blobRef := d.client.BlockBlobClient(path)
+		err := d.retry.do(ctx, func(ctx context.Context) error {
+			_, err := blobRef.SetTier(ctx, d.tiers.rehydrateToTier, &blob.SetTierOptions{
+				RehydratePriority: &priority,
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("azure: failed to request rehydration of %s: %v", path, err)
+		}
+	}
+	return ErrBlobRehydrating{Path: path, RetryAfter: defaultRehydrateRetryAfter}
+}
@@ -0,0 +1,46 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// TestRehydrate_SkipsSetTierWhenAlreadyPending verifies that rehydrate only
+// issues a SetTier request the first time a blob is found archived, not on
+// every subsequent poll of a rehydration that's already in progress.
+func TestRehydrate_SkipsSetTierWhenAlreadyPending(t *testing.T) {
+	var setTierCalls int
+	var mu sync.Mutex
+
+	client := newFakeServiceClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") != "tier" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		mu.Lock()
+		setTierCalls++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	d := &driver{client: client, retry: fastRetry(), tiers: tierPolicy{autoRehydrate: true}}
+
+	// No archiveStatus yet: rehydration hasn't been requested, so this must
+	// issue SetTier.
+	if _, ok := d.rehydrate(context.Background(), "/blob", nil).(ErrBlobRehydrating); !ok {
+		t.Fatalf("expected ErrBlobRehydrating")
+	}
+	// archiveStatus now non-empty, as Azure would report while the earlier
+	// SetTier's rehydration is still in flight: must NOT re-trigger it.
+	rehydrating := "rehydrate-pending-to-hot"
+	if _, ok := d.rehydrate(context.Background(), "/blob", &rehydrating).(ErrBlobRehydrating); !ok {
+		t.Fatalf("expected ErrBlobRehydrating")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if setTierCalls != 1 {
+		t.Fatalf("expected exactly 1 SetTier call, got %d", setTierCalls)
+	}
+}